@@ -0,0 +1,166 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/alextanhongpin/database-design/internal/store"
+	"github.com/playwright-community/playwright-go"
+)
+
+var installBrowserOnce sync.Once
+var installBrowserErr error
+
+// ensureBrowser installs the playwright browser binaries on first use.
+// Subsequent crawl runs find them already present and skip the
+// download.
+func ensureBrowser() error {
+	installBrowserOnce.Do(func() {
+		installBrowserErr = playwright.Install()
+	})
+	return installBrowserErr
+}
+
+// crawl fetches seed and, recursively, same-origin links it finds up
+// to maxDepth, rendering each page with headless Chromium so
+// JavaScript-driven content is captured. Each page is replicated into
+// docs via raft.Apply with path set to its URL; the FTS5 triggers on
+// docs handle indexing unchanged on every node.
+func crawl(s *store.Store, seed string, maxDepth int, delay time.Duration) error {
+	if err := ensureBrowser(); err != nil {
+		return fmt.Errorf("%w: failed to install playwright browsers", err)
+	}
+
+	origin, err := sameOrigin(seed)
+	if err != nil {
+		return fmt.Errorf("%w: invalid seed url %s", err, seed)
+	}
+
+	pw, err := playwright.Run()
+	if err != nil {
+		return fmt.Errorf("%w: failed to start playwright", err)
+	}
+	defer pw.Stop()
+
+	browser, err := pw.Chromium.Launch()
+	if err != nil {
+		return fmt.Errorf("%w: failed to launch chromium", err)
+	}
+	defer browser.Close()
+
+	page, err := browser.NewPage()
+	if err != nil {
+		return fmt.Errorf("%w: failed to open page", err)
+	}
+
+	type queued struct {
+		url   string
+		depth int
+	}
+
+	queue := []queued{{seed, 0}}
+	visited := map[string]bool{}
+
+	for len(queue) > 0 {
+		cur := queue[0]
+		queue = queue[1:]
+
+		if visited[cur.url] {
+			continue
+		}
+		visited[cur.url] = true
+
+		if _, err := page.Goto(cur.url); err != nil {
+			return fmt.Errorf("%w: failed to load %s", err, cur.url)
+		}
+
+		html, err := page.Content()
+		if err != nil {
+			return fmt.Errorf("%w: failed to read content of %s", err, cur.url)
+		}
+
+		cmd, err := store.NewCommand(store.OpUpsertCrawledDoc, map[string]string{
+			"path": cur.url, "markdown": htmlToMarkdown(html), "html": html,
+		})
+		if err != nil {
+			return err
+		}
+		if err := s.Apply(cmd, applyTimeout); err != nil {
+			return fmt.Errorf("%w: failed to index %s", err, cur.url)
+		}
+
+		if cur.depth < maxDepth {
+			links, err := pageLinks(page, origin)
+			if err != nil {
+				return fmt.Errorf("%w: failed to extract links from %s", err, cur.url)
+			}
+			for _, link := range links {
+				if !visited[link] {
+					queue = append(queue, queued{link, cur.depth + 1})
+				}
+			}
+		}
+
+		time.Sleep(delay)
+	}
+
+	return nil
+}
+
+// pageLinks returns every same-origin anchor href reachable from the
+// current page.
+func pageLinks(page playwright.Page, origin string) ([]string, error) {
+	raw, err := page.EvalOnSelectorAll("a[href]", "els => els.map(el => el.href)")
+	if err != nil {
+		return nil, err
+	}
+
+	hrefs, ok := raw.([]any)
+	if !ok {
+		return nil, nil
+	}
+
+	var links []string
+	for _, h := range hrefs {
+		href, ok := h.(string)
+		if !ok {
+			continue
+		}
+		if o, err := sameOrigin(href); err == nil && o == origin {
+			links = append(links, href)
+		}
+	}
+	return links, nil
+}
+
+func sameOrigin(raw string) (string, error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "", err
+	}
+	return u.Scheme + "://" + u.Host, nil
+}
+
+var anyTagRe = regexp.MustCompile(`(?s)<[^>]+>`)
+var whitespaceRe = regexp.MustCompile(`\n{3,}`)
+
+// htmlToMarkdown is a best-effort conversion of rendered page HTML to
+// readable plain text suitable for BM25 ranking: script/style content
+// is dropped, tags are stripped, and runs of blank lines are
+// collapsed. It intentionally doesn't attempt to reproduce markdown
+// formatting (headings, links, ...) — the FTS column only needs
+// ranking-friendly prose.
+func htmlToMarkdown(html string) string {
+	html = regexp.MustCompile(`(?is)<script.*?</script>`).ReplaceAllString(html, "")
+	html = regexp.MustCompile(`(?is)<style.*?</style>`).ReplaceAllString(html, "")
+	html = regexp.MustCompile(`(?i)<(br|p|div|h[1-6]|li)[^>]*>`).ReplaceAllString(html, "\n")
+	text := anyTagRe.ReplaceAllString(html, "")
+	text = strings.ReplaceAll(text, "&nbsp;", " ")
+	text = strings.ReplaceAll(text, "&amp;", "&")
+	text = whitespaceRe.ReplaceAllString(text, "\n\n")
+	return strings.TrimSpace(text)
+}
@@ -1,16 +1,47 @@
 package main
 
 import (
+	"crypto/sha256"
 	"database/sql"
 	"flag"
 	"fmt"
 	"log"
 	"os"
 	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
 
+	querydsl "github.com/alextanhongpin/database-design/internal/query"
+	"github.com/alextanhongpin/database-design/internal/store"
 	_ "github.com/mattn/go-sqlite3"
 )
 
+const applyTimeout = 10 * time.Second
+
+// stringSlice collects repeated flag occurrences, e.g. `-t foo -t bar`.
+type stringSlice []string
+
+func (s *stringSlice) String() string {
+	return strings.Join(*s, ",")
+}
+
+func (s *stringSlice) Set(v string) error {
+	*s = append(*s, v)
+	return nil
+}
+
+// writesFor reports whether cmd mutates docs/tags, and so needs a raft
+// leader to Apply against.
+func writesFor(cmd string) bool {
+	switch cmd {
+	case "index", "crawl", "tag", "untag", "clear":
+		return true
+	default:
+		return false
+	}
+}
+
 func main() {
 	cmd := os.Args[1]
 	if cmd == "" {
@@ -18,50 +49,169 @@ func main() {
 	}
 
 	var query string
+	var include, exclude stringSlice
 	flag.StringVar(&query, "q", "", "search keyword")
+	flag.Var(&include, "t", "required tag, repeatable")
+	flag.Var(&exclude, "x", "excluded tag, repeatable")
+	var maxDepth int
+	flag.IntVar(&maxDepth, "max-depth", 1, "maximum crawl recursion depth")
+	var delay time.Duration
+	flag.DurationVar(&delay, "delay", 200*time.Millisecond, "politeness delay between page fetches")
+	var root string
+	flag.StringVar(&root, "root", ".", "directory to index")
+	var watchFlag bool
+	flag.BoolVar(&watchFlag, "watch", false, "keep indexing as files under root change")
+	var highlightColumn string
+	flag.StringVar(&highlightColumn, "highlight-column", "markdown", "docs_idx column (path|markdown) to highlight in results")
+	var nodeID, raftDir, raftBind string
+	flag.StringVar(&nodeID, "node-id", "cli", "unique id of this node in the raft cluster")
+	flag.StringVar(&raftDir, "raft-dir", "raft-cli", "directory for this node's raft log/snapshots")
+	flag.StringVar(&raftBind, "raft-bind", "127.0.0.1:12001", "address this node's raft transport listens on")
+	var inMemory bool
+	flag.BoolVar(&inMemory, "memory", false, "run the raft log and sqlite database in memory")
+	var bootstrap bool
+	flag.BoolVar(&bootstrap, "bootstrap", true, "form a new single-node cluster around this node; false when joining an existing one")
 	// NOTE: If we have a command before the flag, it will not be interpreted.
 	// We need to start parsing after the first arg.
 	//flag.Parse()
 	flag.CommandLine.Parse(os.Args[2:])
 
-	db, err := sql.Open("sqlite3", "file:search.db?cache=shared")
+	s, err := store.Open(store.Config{
+		NodeID:    nodeID,
+		RaftDir:   raftDir,
+		RaftBind:  raftBind,
+		DBPath:    "file:search.db?cache=shared",
+		InMemory:  inMemory,
+		Bootstrap: bootstrap,
+	})
 	if err != nil {
-		panic(err)
+		log.Fatal(err)
 	}
-	defer db.Close()
+	defer s.Close()
 
-	switch cmd {
-	case "init":
-		fmt.Println("initializing...")
-		if err := initDB(db); err != nil {
+	// Every mutating command goes through raft.Apply, which only the
+	// leader may do — wait for this node's own single-node cluster (or
+	// the one it joined) to settle on one before issuing writes.
+	if writesFor(cmd) {
+		if err := s.WaitForLeader(10 * time.Second); err != nil {
 			log.Fatal(err)
 		}
+	}
+
+	db := s.DB()
+
+	switch cmd {
+	case "init":
+		fmt.Println("schema is ready")
 	case "index":
 		fmt.Println("indexing...")
-		if err := index(db); err != nil {
+		if err := index(s, root); err != nil {
 			log.Fatal(err)
 		}
+		if watchFlag {
+			fmt.Println("watching...", root)
+			if err := watch(s, root); err != nil {
+				log.Fatal(err)
+			}
+		}
 	case "search":
 		fmt.Println("searching...", query)
-		res, err := search(db, query)
+		res, err := search(db, query, include, exclude, highlightColumn)
 		if err != nil {
 			log.Fatal(err)
 		}
 		for i, r := range res {
 			fmt.Printf("%d) %s\n\n%s\n\n", i+1, r.Path, r.Match)
 		}
+	case "tag":
+		args := flag.Args()
+		if len(args) < 2 {
+			log.Fatal("usage: tag <path> <tag>...")
+		}
+		if err := tagDoc(s, args[0], args[1:]); err != nil {
+			log.Fatal(err)
+		}
+	case "untag":
+		args := flag.Args()
+		if len(args) < 2 {
+			log.Fatal("usage: untag <path> <tag>...")
+		}
+		if err := untagDoc(s, args[0], args[1:]); err != nil {
+			log.Fatal(err)
+		}
+	case "tags":
+		args := flag.Args()
+		var tags []string
+		if len(args) > 0 {
+			tags, err = docTags(db, args[0])
+		} else {
+			tags, err = allTags(db)
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+		for _, t := range tags {
+			fmt.Println(t)
+		}
+	case "crawl":
+		args := flag.Args()
+		if len(args) < 1 {
+			log.Fatal("usage: crawl <seed-url>")
+		}
+		fmt.Println("crawling...", args[0])
+		if err := crawl(s, args[0], maxDepth, delay); err != nil {
+			log.Fatal(err)
+		}
+	case "clear":
+		args := flag.Args()
+		if len(args) < 1 {
+			log.Fatal("usage: clear <path>")
+		}
+		if err := clearTags(s, args[0]); err != nil {
+			log.Fatal(err)
+		}
 	default:
 		log.Fatalf("invalid command: %s", cmd)
 	}
 }
 
 type SearchResult struct {
-	Path  string
-	Match string
+	Path      string
+	Match     string
+	Highlight string
 }
 
-func search(db *sql.DB, q string) ([]SearchResult, error) {
-	rows, err := db.Query(`select path, snippet(docs_idx, 1, '<b>', '</b>', '...', 32) from docs_idx where docs_idx match ? order by rank`, q)
+// search returns docs matching the query DSL q (field filters, phrase
+// and prefix operators, AND/OR/NOT grouping — see internal/query),
+// narrowed by required tags (all of include must be present) and
+// excluded tags (none of exclude may be present). highlightColumn
+// selects which docs_idx column snippet() targets ("path" or
+// "markdown"). search is a local read and bypasses raft entirely.
+func search(db *sql.DB, q string, include, exclude []string, highlightColumn string) ([]SearchResult, error) {
+	match, err := querydsl.Render(q)
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid query", err)
+	}
+
+	col := querydsl.ColumnIndex(highlightColumn)
+	stmt := fmt.Sprintf(`select path, snippet(docs_idx, %d, '<b>', '</b>', '...', 32), highlight(docs_idx, %d, '<mark>', '</mark>') from docs_idx where docs_idx match ?`, col, col)
+	args := []any{match}
+
+	for _, tag := range include {
+		stmt += ` and exists (select 1 from doc_tags dt join tags tg on tg.id = dt.tag_id where dt.doc_id = docs_idx.rowid and tg.name = ?)`
+		args = append(args, tag)
+	}
+
+	if len(exclude) > 0 {
+		stmt += ` and not exists (select 1 from doc_tags dt join tags tg on tg.id = dt.tag_id where dt.doc_id = docs_idx.rowid and tg.name in (` + placeholders(len(exclude)) + `))`
+		for _, tag := range exclude {
+			args = append(args, tag)
+		}
+	}
+
+	stmt += ` order by rank`
+
+	rows, err := db.Query(stmt, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -70,7 +220,7 @@ func search(db *sql.DB, q string) ([]SearchResult, error) {
 	var res []SearchResult
 	for rows.Next() {
 		var row SearchResult
-		if err := rows.Scan(&row.Path, &row.Match); err != nil {
+		if err := rows.Scan(&row.Path, &row.Match, &row.Highlight); err != nil {
 			return nil, err
 		}
 
@@ -84,41 +234,137 @@ func search(db *sql.DB, q string) ([]SearchResult, error) {
 	return res, nil
 }
 
-func initDB(db *sql.DB) error {
-	//https://www.sqlite.org/fts5.html#external_content_and_contentless_tables
-	_, err := db.Exec(`create table docs (id integer primary key, path text not null unique, markdown text not null)`)
+func placeholders(n int) string {
+	return strings.TrimSuffix(strings.Repeat("?,", n), ",")
+}
+
+// tagDoc attaches the given tags to the doc at path, creating any tags
+// that don't already exist. Replicated via raft.Apply.
+func tagDoc(s *store.Store, path string, tagNames []string) error {
+	cmd, err := store.NewCommand(store.OpTagDoc, tagArgs(path, tagNames))
 	if err != nil {
 		return err
 	}
+	return s.Apply(cmd, applyTimeout)
+}
 
-	_, err = db.Exec(`create virtual table docs_idx using fts5(path, markdown, content='docs', content_rowid='id')`)
+// untagDoc detaches the given tags from the doc at path. Replicated via
+// raft.Apply.
+func untagDoc(s *store.Store, path string, tagNames []string) error {
+	cmd, err := store.NewCommand(store.OpUntagDoc, tagArgs(path, tagNames))
 	if err != nil {
 		return err
 	}
+	return s.Apply(cmd, applyTimeout)
+}
 
-	_, err = db.Exec(`CREATE TRIGGER docs_ai AFTER INSERT ON docs BEGIN
-  INSERT INTO docs_idx(rowid, path, markdown) VALUES (new.id, new.path, new.markdown);
-END;`)
+// clearTags drops every tag assigned to the doc at path. Replicated via
+// raft.Apply.
+func clearTags(s *store.Store, path string) error {
+	cmd, err := store.NewCommand(store.OpClearTags, map[string]string{"path": path})
 	if err != nil {
 		return err
 	}
+	return s.Apply(cmd, applyTimeout)
+}
 
-	_, err = db.Exec(`CREATE TRIGGER docs_ad AFTER DELETE ON docs BEGIN
-  INSERT INTO docs_idx(docs_idx, rowid, path, markdown) VALUES('delete', old.id, old.path, old.markdown);
-END;`)
+func tagArgs(path string, tags []string) map[string]any {
+	return map[string]any{"path": path, "tags": tags}
+}
+
+// docTags lists the tags assigned to the doc at path.
+func docTags(db *sql.DB, path string) ([]string, error) {
+	rows, err := db.Query(`
+		select tg.name
+		from tags tg
+		join doc_tags dt on dt.tag_id = tg.id
+		join docs d on d.id = dt.doc_id
+		where d.path = ?
+		order by tg.name`, path)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanTags(rows)
+}
+
+// allTags lists every tag in the tags table, including tags that no doc
+// carries any more after untag or clear.
+func allTags(db *sql.DB) ([]string, error) {
+	rows, err := db.Query(`select name from tags order by name`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanTags(rows)
+}
+
+func scanTags(rows *sql.Rows) ([]string, error) {
+	var tags []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		tags = append(tags, name)
+	}
+	return tags, rows.Err()
+}
+
+var frontmatterRe = regexp.MustCompile(`(?s)^---\n(.*?)\n---\n?`)
+var frontmatterTagsRe = regexp.MustCompile(`(?m)^tags:[ \t]*(.*)$`)
+var frontmatterListItemRe = regexp.MustCompile(`(?m)^\s*-\s*(\S+)\s*$`)
+var frontmatterKeyRe = regexp.MustCompile(`(?m)^[^\s-]`)
+
+// frontmatterTags extracts the `tags:` list from a leading YAML
+// frontmatter block, supporting both the inline `tags: [a, b]` form
+// and the block list form:
+//
+//	tags:
+//	  - a
+//	  - b
+func frontmatterTags(markdown string) []string {
+	m := frontmatterRe.FindStringSubmatch(markdown)
+	if m == nil {
+		return nil
+	}
+	frontmatter := m[1]
+
+	tagsMatch := frontmatterTagsRe.FindStringSubmatch(frontmatter)
+	if tagsMatch == nil {
+		return nil
+	}
+
+	inline := strings.TrimSpace(tagsMatch[1])
+	if strings.HasPrefix(inline, "[") {
+		inline = strings.TrimSuffix(strings.TrimPrefix(inline, "["), "]")
+		var tags []string
+		for _, t := range strings.Split(inline, ",") {
+			t = strings.TrimSpace(t)
+			if t != "" {
+				tags = append(tags, t)
+			}
+		}
+		return tags
 	}
 
-	_, err = db.Exec(`CREATE TRIGGER docs_au AFTER UPDATE ON docs BEGIN
-  INSERT INTO docs_idx(docs_idx, rowid, path, markdown) VALUES('delete', old.id, old.path, old.markdown);
-  INSERT INTO docs_idx(rowid, path, markdown) VALUES (new.id, new.path, new.markdown);
-END;`)
-	return err
+	// Block list: the lines following `tags:` up to the next
+	// non-indented key.
+	rest := frontmatter[strings.Index(frontmatter, tagsMatch[0])+len(tagsMatch[0]):]
+	if loc := frontmatterKeyRe.FindStringIndex(rest); loc != nil {
+		rest = rest[:loc[0]]
+	}
+	var tags []string
+	for _, m := range frontmatterListItemRe.FindAllStringSubmatch(rest, -1) {
+		tags = append(tags, m[1])
+	}
+	return tags
 }
 
-func index(db *sql.DB) error {
-	return filepath.Walk(".",
+func index(s *store.Store, root string) error {
+	return filepath.Walk(root,
 		func(path string, info os.FileInfo, err error) error {
 			if err != nil {
 				return err
@@ -129,18 +375,53 @@ func index(db *sql.DB) error {
 				return nil
 			}
 
-			b, err := os.ReadFile(path)
-			if err != nil {
-				return fmt.Errorf("%w: failed to read file %s", err, path)
-			}
+			return indexFile(s, path)
+		})
+}
 
-			markdown := string(b)
+// indexFile reads a single markdown file and replicates it into docs
+// via raft.Apply, skipping the write entirely when its content hash
+// matches what's already stored locally — an unchanged file would
+// otherwise still fire the docs_au trigger's delete+reinsert into
+// docs_idx, on every node, for nothing.
+func indexFile(s *store.Store, path string) error {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("%w: failed to read file %s", err, path)
+	}
 
-			_, err = db.Exec(`insert into docs(path, markdown) values (?, ?) on conflict (path) do update set markdown = excluded.markdown`, path, markdown)
-			if err != nil {
-				return fmt.Errorf("%w: failed to insert %s", err, path)
-			}
+	markdown := string(b)
+	hash := fmt.Sprintf("%x", sha256.Sum256(b))
 
-			return nil
-		})
+	var existingHash string
+	err = s.DB().QueryRow(`select sha256 from docs where path = ?`, path).Scan(&existingHash)
+	if err != nil && err != sql.ErrNoRows {
+		return fmt.Errorf("%w: failed to look up hash for %s", err, path)
+	}
+	if existingHash == hash {
+		return nil
+	}
+
+	cmd, err := store.NewCommand(store.OpIndexDoc, map[string]any{
+		"path": path, "markdown": markdown, "sha256": hash, "tags": frontmatterTags(markdown),
+	})
+	if err != nil {
+		return err
+	}
+	if err := s.Apply(cmd, applyTimeout); err != nil {
+		return fmt.Errorf("%w: failed to index %s", err, path)
+	}
+
+	return nil
+}
+
+// deleteFile removes the doc for path via raft.Apply, driving the
+// docs_ad trigger (and the doc_tags foreign key's on delete cascade) on
+// every node.
+func deleteFile(s *store.Store, path string) error {
+	cmd, err := store.NewCommand(store.OpDeleteDoc, map[string]string{"path": path})
+	if err != nil {
+		return err
+	}
+	return s.Apply(cmd, applyTimeout)
 }
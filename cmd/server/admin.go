@@ -0,0 +1,139 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/alextanhongpin/database-design/internal/store"
+)
+
+const applyTimeout = 10 * time.Second
+
+// adminMux serves cluster-membership endpoints on the internal admin
+// port. These are separate from the public search API so operators can
+// expose 8080 to users while keeping /join and /leave behind a
+// firewall.
+func adminMux(s *store.Store) http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/join", func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			NodeID string `json:"node_id"`
+			Addr   string `json:"addr"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if err := s.Join(req.NodeID, req.Addr); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	})
+
+	mux.HandleFunc("/leave", func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			NodeID string `json:"node_id"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if err := s.Leave(req.NodeID); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	})
+
+	return mux
+}
+
+// docsHandler lets clients index or delete a doc against the cluster;
+// the write is replicated via raft.Apply before the handler responds.
+func docsHandler(s *store.Store) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodPut:
+			var req struct {
+				Path     string `json:"path"`
+				Markdown string `json:"markdown"`
+			}
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+
+			cmd, err := store.NewCommand(store.OpUpsertDoc, req)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			if err := s.Apply(cmd, applyTimeout); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+			}
+
+		case http.MethodDelete:
+			var req struct {
+				Path string `json:"path"`
+			}
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+
+			cmd, err := store.NewCommand(store.OpDeleteDoc, req)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			if err := s.Apply(cmd, applyTimeout); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+			}
+
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+}
+
+// docTagsHandler lets clients tag/untag/clear a doc's tags against the
+// cluster, mirroring the CLI's `tag`/`untag`/`clear` subcommands.
+func docTagsHandler(s *store.Store) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Path string   `json:"path"`
+			Tags []string `json:"tags"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		var op string
+		switch r.Method {
+		case http.MethodPut:
+			op = store.OpTagDoc
+		case http.MethodDelete:
+			if len(req.Tags) == 0 {
+				op = store.OpClearTags
+			} else {
+				op = store.OpUntagDoc
+			}
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		cmd, err := store.NewCommand(op, req)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if err := s.Apply(cmd, applyTimeout); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	})
+}
@@ -3,10 +3,16 @@ package main
 import (
 	"database/sql"
 	"embed"
+	"encoding/base64"
+	"encoding/json"
+	"flag"
 	"fmt"
 	"html/template"
 	"net/http"
+	"strconv"
 
+	"github.com/alextanhongpin/database-design/internal/query"
+	"github.com/alextanhongpin/database-design/internal/store"
 	_ "github.com/mattn/go-sqlite3"
 )
 
@@ -25,15 +31,43 @@ var templateFunc = map[string]any{
 var layout = template.Must(template.ParseFS(files, "html/layout.html")).Funcs(templateFunc)
 var templates = template.Must(layout.ParseFS(files, "html/home.html"))
 
+const defaultLimit = 10
+
 func main() {
-	db, err := sql.Open("sqlite3", "file:search.db?cache=shared")
+	var nodeID, raftDir, raftBind, adminAddr string
+	var inMemory, bootstrap bool
+	flag.StringVar(&nodeID, "node-id", "node1", "unique id of this node in the raft cluster")
+	flag.StringVar(&raftDir, "raft-dir", "raft", "directory for this node's raft log/snapshots")
+	flag.StringVar(&raftBind, "raft-bind", "127.0.0.1:12000", "address this node's raft transport listens on")
+	flag.StringVar(&adminAddr, "admin-addr", "127.0.0.1:8081", "internal admin address serving /join and /leave")
+	flag.BoolVar(&inMemory, "memory", false, "run the raft log and sqlite database in memory, for ephemeral test clusters")
+	flag.BoolVar(&bootstrap, "bootstrap", true, "form a new single-node cluster around this node; false when joining an existing one")
+	flag.Parse()
+
+	s, err := store.Open(store.Config{
+		NodeID:    nodeID,
+		RaftDir:   raftDir,
+		RaftBind:  raftBind,
+		DBPath:    "file:search.db?cache=shared",
+		InMemory:  inMemory,
+		Bootstrap: bootstrap,
+	})
 	if err != nil {
 		panic(err)
 	}
-	defer db.Close()
+	defer s.Close()
 
+	db := s.DB()
 	mux := http.NewServeMux()
 	mux.Handle("/", home(db))
+	mux.Handle("/api/v1/search", api(db))
+	mux.Handle("/api/v1/docs", docsHandler(s))
+	mux.Handle("/api/v1/docs/tags", docTagsHandler(s))
+
+	go func() {
+		fmt.Println("Admin API listening on", adminAddr)
+		http.ListenAndServe(adminAddr, adminMux(s))
+	}()
 
 	fmt.Println("Listening to port *:8080. Press ctrl + c to cancel.")
 	http.ListenAndServe(":8080", mux)
@@ -41,12 +75,18 @@ func main() {
 
 func home(db *sql.DB) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if wantsJSON(r) {
+			api(db).ServeHTTP(w, r)
+			return
+		}
+
 		var data map[string]any
 		q := r.URL.Query().Get("q")
 		if q != "" {
-			res, err := search(db, q)
+			limit, offset := parsePagination(r)
+			res, _, err := search(db, q, limit, offset, highlightColumn(r))
 			if err != nil {
-				http.Error(w, err.Error(), http.StatusInternalServerError)
+				http.Error(w, err.Error(), http.StatusBadRequest)
 				return
 			}
 
@@ -63,38 +103,156 @@ func home(db *sql.DB) http.Handler {
 	})
 }
 
+// wantsJSON reports whether the request is asking for the JSON REST
+// representation rather than the rendered HTML page.
+func wantsJSON(r *http.Request) bool {
+	return r.Header.Get("Accept") == "application/json"
+}
+
+// api serves the JSON REST equivalent of home, mounted at
+// /api/v1/search so clients can page through results without scraping
+// the HTML UI.
+func api(db *sql.DB) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query().Get("q")
+		limit, offset := parsePagination(r)
+
+		res, total, err := search(db, q, limit, offset, highlightColumn(r))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		resp := SearchResponse{
+			Query: q,
+			Total: total,
+			Hits:  res,
+		}
+		if offset+limit < total {
+			resp.NextCursor = encodeCursor(offset + limit)
+		}
+		if offset > 0 {
+			prev := offset - limit
+			if prev < 0 {
+				prev = 0
+			}
+			resp.PrevCursor = encodeCursor(prev)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(resp); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	})
+}
+
+// highlightColumn reads the highlight-column query param ("path" or
+// "markdown") controlling which docs_idx column snippet()/highlight()
+// target, defaulting to "markdown".
+func highlightColumn(r *http.Request) string {
+	if v := r.URL.Query().Get("highlight-column"); v != "" {
+		return v
+	}
+	return "markdown"
+}
+
+// parsePagination reads limit/offset from the query string, or a
+// cursor token produced by encodeCursor, falling back to defaultLimit
+// and offset 0.
+func parsePagination(r *http.Request) (limit, offset int) {
+	limit = defaultLimit
+	if v := r.URL.Query().Get("limit"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n > 0 {
+			limit = n
+		}
+	}
+
+	if v := r.URL.Query().Get("cursor"); v != "" {
+		if n, err := decodeCursor(v); err == nil {
+			return limit, n
+		}
+	}
+
+	if v := r.URL.Query().Get("offset"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n >= 0 {
+			offset = n
+		}
+	}
+
+	return limit, offset
+}
+
+func encodeCursor(offset int) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.Itoa(offset)))
+}
+
+func decodeCursor(token string) (int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(string(b))
+}
+
 type SearchResult struct {
-	Path  string
-	Match string
+	Path      string
+	Match     string
+	Highlight string
+	Score     float64
+}
+
+// SearchResponse is the JSON REST representation of a search, mirroring
+// the split HTML/REST design used by servers like Zoekt: the query that
+// was run, the total number of matches, the current page of hits, and
+// cursor tokens for paging forwards/backwards.
+type SearchResponse struct {
+	Query      string         `json:"query"`
+	Total      int            `json:"total"`
+	Hits       []SearchResult `json:"hits"`
+	NextCursor string         `json:"next_cursor,omitempty"`
+	PrevCursor string         `json:"prev_cursor,omitempty"`
 }
 
-func search(db *sql.DB, q string) ([]SearchResult, error) {
-	rows, err := db.Query(`
+func search(db *sql.DB, q string, limit, offset int, highlightCol string) ([]SearchResult, int, error) {
+	match, err := query.Render(q)
+	if err != nil {
+		return nil, 0, fmt.Errorf("%w: invalid query", err)
+	}
+
+	var total int
+	if err := db.QueryRow(`select count(*) from docs_idx where docs_idx match ?`, match).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	col := query.ColumnIndex(highlightCol)
+	rows, err := db.Query(fmt.Sprintf(`
 		select
-			path, 
-			snippet(docs_idx, 1, '<b>', '</b>', '...', 32) 
-		from docs_idx 
-		where docs_idx match ? 
+			path,
+			snippet(docs_idx, %d, '<b>', '</b>', '...', 32),
+			highlight(docs_idx, %d, '<mark>', '</mark>'),
+			bm25(docs_idx)
+		from docs_idx
+		where docs_idx match ?
 		order by bm25(docs_idx)
-		limit 10`, q)
+		limit ? offset ?`, col, col), match, limit, offset)
 	if err != nil {
-		return nil, err
+		return nil, 0, err
 	}
 	defer rows.Close()
 
 	var res []SearchResult
 	for rows.Next() {
 		var row SearchResult
-		if err := rows.Scan(&row.Path, &row.Match); err != nil {
-			return nil, err
+		if err := rows.Scan(&row.Path, &row.Match, &row.Highlight, &row.Score); err != nil {
+			return nil, 0, err
 		}
 
 		res = append(res, row)
 	}
 
 	if err := rows.Err(); err != nil {
-		return nil, err
+		return nil, 0, err
 	}
 
-	return res, nil
+	return res, total, nil
 }
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/alextanhongpin/database-design/internal/store"
+	"github.com/fsnotify/fsnotify"
+)
+
+const debounceDelay = 100 * time.Millisecond
+
+// watch keeps the process running and applies create/modify/delete
+// events under root to docs via raft.Apply, debouncing per path so
+// editors that emit several events per save (write + chmod, typically)
+// only trigger one reindex.
+func watch(s *store.Store, root string) error {
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		return fmt.Errorf("%w: failed to start watcher", err)
+	}
+	defer w.Close()
+
+	if err := addDirs(w, root); err != nil {
+		return err
+	}
+
+	var mu sync.Mutex
+	timers := map[string]*time.Timer{}
+
+	debounce := func(path string, apply func()) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		if t, ok := timers[path]; ok {
+			t.Stop()
+		}
+		timers[path] = time.AfterFunc(debounceDelay, apply)
+	}
+
+	for {
+		select {
+		case event, ok := <-w.Events:
+			if !ok {
+				return nil
+			}
+
+			if filepath.Ext(event.Name) != ".md" {
+				continue
+			}
+
+			path := event.Name
+			switch {
+			case event.Op&(fsnotify.Create|fsnotify.Write) != 0:
+				debounce(path, func() {
+					if err := indexFile(s, path); err != nil {
+						fmt.Println("watch:", err)
+					}
+				})
+			case event.Op&(fsnotify.Remove|fsnotify.Rename) != 0:
+				debounce(path, func() {
+					if err := deleteFile(s, path); err != nil {
+						fmt.Println("watch:", err)
+					}
+				})
+			}
+		case err, ok := <-w.Errors:
+			if !ok {
+				return nil
+			}
+			fmt.Println("watch:", err)
+		}
+	}
+}
+
+// addDirs registers root and every subdirectory with w, since fsnotify
+// watches are not recursive.
+func addDirs(w *fsnotify.Watcher, root string) error {
+	return filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return w.Add(path)
+		}
+		return nil
+	})
+}
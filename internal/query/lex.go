@@ -0,0 +1,102 @@
+package query
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+type tokenKind int
+
+const (
+	tokWord tokenKind = iota
+	tokPhrase
+	tokKeyword
+	tokColon
+	tokLParen
+	tokRParen
+)
+
+type token struct {
+	kind tokenKind
+	text string
+}
+
+// lex tokenizes a query string, recognizing quoted phrases, parens,
+// `:` field filters, and the AND/OR/NOT keywords (case-insensitive).
+func lex(q string) ([]token, error) {
+	var toks []token
+	r := []rune(q)
+	i := 0
+
+	for i < len(r) {
+		c := r[i]
+		switch {
+		case unicode.IsSpace(c):
+			i++
+
+		case c == '(':
+			toks = append(toks, token{tokLParen, "("})
+			i++
+
+		case c == ')':
+			toks = append(toks, token{tokRParen, ")"})
+			i++
+
+		case c == ':':
+			toks = append(toks, token{tokColon, ":"})
+			i++
+
+		case c == '"':
+			j := i + 1
+			var sb strings.Builder
+			closed := false
+			for j < len(r) {
+				if r[j] == '"' {
+					closed = true
+					j++
+					break
+				}
+				sb.WriteRune(r[j])
+				j++
+			}
+			if !closed {
+				return nil, fmt.Errorf("unterminated phrase starting at %d", i)
+			}
+			toks = append(toks, token{tokPhrase, sb.String()})
+			i = j
+
+		default:
+			j := i
+			for j < len(r) && !unicode.IsSpace(r[j]) && r[j] != '(' && r[j] != ')' && r[j] != ':' && r[j] != '"' {
+				j++
+			}
+			word := string(r[i:j])
+			i = j
+
+			switch strings.ToUpper(word) {
+			case "AND", "OR", "NOT":
+				toks = append(toks, token{tokKeyword, strings.ToUpper(word)})
+			default:
+				toks = append(toks, token{tokWord, word})
+			}
+		}
+	}
+
+	depth := 0
+	for _, t := range toks {
+		if t.kind == tokLParen {
+			depth++
+		} else if t.kind == tokRParen {
+			depth--
+		}
+		if depth < 0 {
+			return nil, fmt.Errorf("unbalanced parens")
+		}
+	}
+	if depth != 0 {
+		return nil, fmt.Errorf("unbalanced parens")
+	}
+
+	return toks, nil
+}
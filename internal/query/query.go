@@ -0,0 +1,281 @@
+// Package query implements a small DSL for search queries and renders
+// it to FTS5's native MATCH syntax, so callers never have to build FTS5
+// expressions by hand.
+//
+// Supported syntax:
+//
+//	path:foo           restrict a term to the `path` column
+//	"exact phrase"      phrase match
+//	term*               prefix match
+//	a AND b, a OR b, a NOT b, and parens for grouping
+//
+// NOT is FTS5's binary infix form ("match a but not b"), not a unary
+// negation — FTS5 itself has no unary NOT.
+package query
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Node is a parsed query expression that knows how to render itself as
+// an FTS5 MATCH string.
+type Node interface {
+	render(sb *strings.Builder)
+}
+
+// Term is a single word, optionally a prefix match (`foo*`).
+type Term struct {
+	Value  string
+	Prefix bool
+}
+
+func (t Term) render(sb *strings.Builder) {
+	sb.WriteString(escapeTerm(t.Value))
+	if t.Prefix {
+		sb.WriteByte('*')
+	}
+}
+
+// Phrase is a quoted exact-match sequence of words.
+type Phrase struct {
+	Value string
+}
+
+func (p Phrase) render(sb *strings.Builder) {
+	sb.WriteByte('"')
+	sb.WriteString(strings.ReplaceAll(p.Value, `"`, `""`))
+	sb.WriteByte('"')
+}
+
+// Field restricts Node's match to a single FTS5 column, e.g. `path:foo`.
+type Field struct {
+	Name string
+	Node Node
+}
+
+func (f Field) render(sb *strings.Builder) {
+	sb.WriteString(f.Name)
+	sb.WriteString(": ")
+	f.Node.render(sb)
+}
+
+// And, Or and Not mirror FTS5's boolean operators. Not is binary
+// ("left NOT right", i.e. "left but not right") — FTS5's grammar has
+// no unary NOT, so there's no Node for one.
+type And struct{ Left, Right Node }
+type Or struct{ Left, Right Node }
+type Not struct{ Left, Right Node }
+
+func (n And) render(sb *strings.Builder) { renderBinary(sb, "AND", n.Left, n.Right) }
+func (n Or) render(sb *strings.Builder)  { renderBinary(sb, "OR", n.Left, n.Right) }
+func (n Not) render(sb *strings.Builder) { renderBinary(sb, "NOT", n.Left, n.Right) }
+
+func renderBinary(sb *strings.Builder, op string, left, right Node) {
+	sb.WriteByte('(')
+	left.render(sb)
+	sb.WriteByte(' ')
+	sb.WriteString(op)
+	sb.WriteByte(' ')
+	right.render(sb)
+	sb.WriteByte(')')
+}
+
+// fts5Special are characters FTS5's bareword tokenizer treats
+// specially even mid-word (confirmed against sqlite3: `MATCH
+// 'well-known'` raises "no such column: known"). Any term containing
+// one must be quoted so FTS5 treats it as a single string token instead
+// of parsing it as query syntax.
+const fts5Special = ` "()-^{}`
+
+func escapeTerm(s string) string {
+	if strings.ContainsAny(s, fts5Special) {
+		return `"` + strings.ReplaceAll(s, `"`, `""`) + `"`
+	}
+	return s
+}
+
+// Render parses q and returns the equivalent FTS5 MATCH string.
+func Render(q string) (string, error) {
+	node, err := Parse(q)
+	if err != nil {
+		return "", err
+	}
+	if node == nil {
+		return "", nil
+	}
+
+	var sb strings.Builder
+	node.render(&sb)
+	return sb.String(), nil
+}
+
+// Parse builds an AST for q. It returns an error (rather than
+// panicking) on unbalanced parens or other malformed input so HTTP
+// handlers can turn it into a 400.
+func Parse(q string) (Node, error) {
+	toks, err := lex(q)
+	if err != nil {
+		return nil, err
+	}
+	if len(toks) == 0 {
+		return nil, nil
+	}
+
+	p := &parser{toks: toks}
+	node, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos != len(p.toks) {
+		return nil, fmt.Errorf("unexpected token %q", p.toks[p.pos].text)
+	}
+	return node, nil
+}
+
+type parser struct {
+	toks []token
+	pos  int
+}
+
+func (p *parser) peek() (token, bool) {
+	if p.pos >= len(p.toks) {
+		return token{}, false
+	}
+	return p.toks[p.pos], true
+}
+
+func (p *parser) next() (token, bool) {
+	t, ok := p.peek()
+	if ok {
+		p.pos++
+	}
+	return t, ok
+}
+
+// parseOr := parseAnd (OR parseAnd)*
+func (p *parser) parseOr() (Node, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		t, ok := p.peek()
+		if !ok || t.kind != tokKeyword || !strings.EqualFold(t.text, "OR") {
+			return left, nil
+		}
+		p.next()
+
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = Or{Left: left, Right: right}
+	}
+}
+
+// parseAnd := parseNot (AND parseNot)*
+func (p *parser) parseAnd() (Node, error) {
+	left, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		t, ok := p.peek()
+		if !ok || t.kind != tokKeyword || !strings.EqualFold(t.text, "AND") {
+			return left, nil
+		}
+		p.next()
+
+		right, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		left = And{Left: left, Right: right}
+	}
+}
+
+// parseNot := parsePrimary (NOT parsePrimary)*
+func (p *parser) parseNot() (Node, error) {
+	left, err := p.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		t, ok := p.peek()
+		if !ok || t.kind != tokKeyword || !strings.EqualFold(t.text, "NOT") {
+			return left, nil
+		}
+		p.next()
+
+		right, err := p.parsePrimary()
+		if err != nil {
+			return nil, err
+		}
+		left = Not{Left: left, Right: right}
+	}
+}
+
+// parsePrimary := '(' parseOr ')' | field? (term | phrase)
+func (p *parser) parsePrimary() (Node, error) {
+	t, ok := p.next()
+	if !ok {
+		return nil, fmt.Errorf("unexpected end of query")
+	}
+
+	switch t.kind {
+	case tokLParen:
+		node, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		closing, ok := p.next()
+		if !ok || closing.kind != tokRParen {
+			return nil, fmt.Errorf("unbalanced parens")
+		}
+		return node, nil
+
+	case tokPhrase:
+		return Phrase{Value: t.text}, nil
+
+	case tokWord:
+		if nt, ok := p.peek(); ok && nt.kind == tokColon {
+			p.next()
+			value, ok := p.next()
+			if !ok || (value.kind != tokWord && value.kind != tokPhrase) {
+				return nil, fmt.Errorf("expected term after %q:", t.text)
+			}
+			var node Node
+			if value.kind == tokPhrase {
+				node = Phrase{Value: value.text}
+			} else {
+				node = termFromWord(value.text)
+			}
+			return Field{Name: t.text, Node: node}, nil
+		}
+		return termFromWord(t.text), nil
+
+	default:
+		return nil, fmt.Errorf("unexpected token %q", t.text)
+	}
+}
+
+// ColumnIndex maps a docs_idx column name to the integer index FTS5
+// functions like snippet() and highlight() expect. Unknown names fall
+// back to the markdown column, since that's what's searched by default.
+func ColumnIndex(name string) int {
+	if name == "path" {
+		return 0
+	}
+	return 1
+}
+
+func termFromWord(w string) Term {
+	if strings.HasSuffix(w, "*") {
+		return Term{Value: strings.TrimSuffix(w, "*"), Prefix: true}
+	}
+	return Term{Value: w}
+}
@@ -0,0 +1,144 @@
+package store
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// dump is the snapshot wire format: a full logical copy of
+// docs/tags/doc_tags, serialized as JSON rather than a SQL script —
+// arbitrary indexed/crawled content can itself contain anything
+// (semicolons, quotes, newlines), so a text dump would need a real SQL
+// tokenizer to replay safely. JSON encode/decode sidesteps that
+// entirely. docs_idx is intentionally excluded: it's rebuilt by the
+// docs_ai trigger as each doc row is reinserted.
+type dump struct {
+	Docs    []docRow    `json:"docs"`
+	Tags    []tagRow    `json:"tags"`
+	DocTags []docTagRow `json:"doc_tags"`
+}
+
+type docRow struct {
+	ID       int64   `json:"id"`
+	Path     string  `json:"path"`
+	Markdown string  `json:"markdown"`
+	HTML     *string `json:"html"`
+	SHA256   *string `json:"sha256"`
+}
+
+type tagRow struct {
+	ID   int64  `json:"id"`
+	Name string `json:"name"`
+}
+
+type docTagRow struct {
+	DocID int64 `json:"doc_id"`
+	TagID int64 `json:"tag_id"`
+}
+
+// encodeDump writes a dump of docs/tags/doc_tags to w.
+func encodeDump(db *sql.DB, w io.Writer) error {
+	d := dump{}
+
+	rows, err := db.Query(`select id, path, markdown, html, sha256 from docs`)
+	if err != nil {
+		return fmt.Errorf("%w: failed to dump docs", err)
+	}
+	for rows.Next() {
+		var row docRow
+		if err := rows.Scan(&row.ID, &row.Path, &row.Markdown, &row.HTML, &row.SHA256); err != nil {
+			rows.Close()
+			return fmt.Errorf("%w: failed to scan docs row", err)
+		}
+		d.Docs = append(d.Docs, row)
+	}
+	if err := rows.Err(); err != nil {
+		rows.Close()
+		return err
+	}
+	rows.Close()
+
+	rows, err = db.Query(`select id, name from tags`)
+	if err != nil {
+		return fmt.Errorf("%w: failed to dump tags", err)
+	}
+	for rows.Next() {
+		var row tagRow
+		if err := rows.Scan(&row.ID, &row.Name); err != nil {
+			rows.Close()
+			return fmt.Errorf("%w: failed to scan tags row", err)
+		}
+		d.Tags = append(d.Tags, row)
+	}
+	if err := rows.Err(); err != nil {
+		rows.Close()
+		return err
+	}
+	rows.Close()
+
+	rows, err = db.Query(`select doc_id, tag_id from doc_tags`)
+	if err != nil {
+		return fmt.Errorf("%w: failed to dump doc_tags", err)
+	}
+	for rows.Next() {
+		var row docTagRow
+		if err := rows.Scan(&row.DocID, &row.TagID); err != nil {
+			rows.Close()
+			return fmt.Errorf("%w: failed to scan doc_tags row", err)
+		}
+		d.DocTags = append(d.DocTags, row)
+	}
+	if err := rows.Err(); err != nil {
+		rows.Close()
+		return err
+	}
+	rows.Close()
+
+	return json.NewEncoder(w).Encode(d)
+}
+
+func decodeDump(r io.Reader) (dump, error) {
+	var d dump
+	err := json.NewDecoder(r).Decode(&d)
+	return d, err
+}
+
+// restoreDump replaces docs/tags/doc_tags with d's contents, inside a
+// single transaction so a partially-applied restore can never be
+// observed.
+func restoreDump(db *sql.DB, d dump) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, table := range []string{"doc_tags", "tags", "docs"} {
+		if _, err := tx.Exec(`delete from ` + table); err != nil {
+			return fmt.Errorf("%w: failed to clear %s before restore", err, table)
+		}
+	}
+
+	for _, row := range d.Docs {
+		if _, err := tx.Exec(`insert into docs(id, path, markdown, html, sha256) values (?, ?, ?, ?, ?)`,
+			row.ID, row.Path, row.Markdown, row.HTML, row.SHA256); err != nil {
+			return fmt.Errorf("%w: failed to restore doc %s", err, row.Path)
+		}
+	}
+
+	for _, row := range d.Tags {
+		if _, err := tx.Exec(`insert into tags(id, name) values (?, ?)`, row.ID, row.Name); err != nil {
+			return fmt.Errorf("%w: failed to restore tag %s", err, row.Name)
+		}
+	}
+
+	for _, row := range d.DocTags {
+		if _, err := tx.Exec(`insert into doc_tags(doc_id, tag_id) values (?, ?)`, row.DocID, row.TagID); err != nil {
+			return fmt.Errorf("%w: failed to restore doc_tags row", err)
+		}
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,333 @@
+package store
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/hashicorp/raft"
+)
+
+// Command is the envelope every write goes through raft.Apply as. Op
+// selects which mutation to run against the local sqlite database; Args
+// is decoded according to Op.
+type Command struct {
+	Op   string          `json:"op"`
+	Args json.RawMessage `json:"args"`
+}
+
+const (
+	// OpUpsertDoc upserts a doc's path/markdown/sha256 with no tag
+	// reconciliation, used by the server's generic doc API.
+	OpUpsertDoc = "upsert_doc"
+	// OpUpsertCrawledDoc upserts a doc's path/markdown/html, used by the
+	// `crawl` subcommand, which has no frontmatter hash to track.
+	OpUpsertCrawledDoc = "upsert_crawled_doc"
+	OpDeleteDoc        = "delete_doc"
+	// OpIndexDoc upserts a doc's path/markdown/sha256 and reconciles its
+	// tags to Tags in one raft log entry, so the `index` subcommand's
+	// content-hash upsert and frontmatter reconciliation commit or fail
+	// together instead of as two independently-replicated writes.
+	OpIndexDoc = "index_doc"
+	// OpTagDoc/OpUntagDoc are additive/subtractive, used by the explicit
+	// `tag`/`untag` subcommands.
+	OpTagDoc    = "tag_doc"
+	OpUntagDoc  = "untag_doc"
+	OpClearTags = "clear_tags"
+)
+
+func (c Command) encode() ([]byte, error) {
+	return json.Marshal(c)
+}
+
+// NewCommand builds a Command for op, encoding args as its payload.
+func NewCommand(op string, args any) (Command, error) {
+	b, err := json.Marshal(args)
+	if err != nil {
+		return Command{}, err
+	}
+	return Command{Op: op, Args: b}, nil
+}
+
+type upsertDocArgs struct {
+	Path     string `json:"path"`
+	Markdown string `json:"markdown"`
+	SHA256   string `json:"sha256"`
+}
+
+type upsertCrawledDocArgs struct {
+	Path     string `json:"path"`
+	Markdown string `json:"markdown"`
+	HTML     string `json:"html"`
+}
+
+type deleteDocArgs struct {
+	Path string `json:"path"`
+}
+
+type indexDocArgs struct {
+	Path     string   `json:"path"`
+	Markdown string   `json:"markdown"`
+	SHA256   string   `json:"sha256"`
+	Tags     []string `json:"tags"`
+}
+
+type tagDocArgs struct {
+	Path string   `json:"path"`
+	Tags []string `json:"tags"`
+}
+
+// execer is satisfied by both *sql.DB and *sql.Tx, so the tag-mutating
+// helpers below can run either standalone (single-statement ops need
+// no transaction of their own) or as part of a larger transaction
+// (e.g. OpIndexDoc's upsert-then-reconcile).
+type execer interface {
+	Exec(query string, args ...any) (sql.Result, error)
+	Query(query string, args ...any) (*sql.Rows, error)
+	QueryRow(query string, args ...any) *sql.Row
+}
+
+// fsm applies Commands to the local sqlite database. It's the only
+// thing allowed to mutate docs/tags/doc_tags once a node has joined a
+// cluster — every write reaches it via consensus first.
+type fsm struct {
+	db *sql.DB
+}
+
+// withTx runs fn inside a transaction, committing on success and
+// rolling back on any error (including a panic unwound through
+// Rollback's defer) — used by every command whose correctness depends
+// on more than one statement succeeding together.
+func (f *fsm) withTx(fn func(execer) error) error {
+	tx, err := f.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := fn(tx); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Apply decodes the Raft log entry as a Command and executes it. The
+// returned value (or error) becomes the ApplyFuture's Response.
+func (f *fsm) Apply(entry *raft.Log) any {
+	var cmd Command
+	if err := json.Unmarshal(entry.Data, &cmd); err != nil {
+		return fmt.Errorf("%w: failed to decode command", err)
+	}
+
+	switch cmd.Op {
+	case OpUpsertDoc:
+		var args upsertDocArgs
+		if err := json.Unmarshal(cmd.Args, &args); err != nil {
+			return err
+		}
+		_, err := f.db.Exec(`insert into docs(path, markdown, sha256) values (?, ?, ?) on conflict (path) do update set markdown = excluded.markdown, sha256 = excluded.sha256`, args.Path, args.Markdown, args.SHA256)
+		return err
+
+	case OpUpsertCrawledDoc:
+		var args upsertCrawledDocArgs
+		if err := json.Unmarshal(cmd.Args, &args); err != nil {
+			return err
+		}
+		_, err := f.db.Exec(`insert into docs(path, markdown, html) values (?, ?, ?) on conflict (path) do update set markdown = excluded.markdown, html = excluded.html`, args.Path, args.Markdown, args.HTML)
+		return err
+
+	case OpDeleteDoc:
+		var args deleteDocArgs
+		if err := json.Unmarshal(cmd.Args, &args); err != nil {
+			return err
+		}
+		_, err := f.db.Exec(`delete from docs where path = ?`, args.Path)
+		return err
+
+	case OpIndexDoc:
+		var args indexDocArgs
+		if err := json.Unmarshal(cmd.Args, &args); err != nil {
+			return err
+		}
+		return f.withTx(func(tx execer) error {
+			if _, err := tx.Exec(`insert into docs(path, markdown, sha256) values (?, ?, ?) on conflict (path) do update set markdown = excluded.markdown, sha256 = excluded.sha256`, args.Path, args.Markdown, args.SHA256); err != nil {
+				return err
+			}
+			return reconcileTags(tx, args.Path, args.Tags)
+		})
+
+	case OpTagDoc:
+		var args tagDocArgs
+		if err := json.Unmarshal(cmd.Args, &args); err != nil {
+			return err
+		}
+		return f.withTx(func(tx execer) error {
+			return tagDoc(tx, args.Path, args.Tags)
+		})
+
+	case OpUntagDoc:
+		var args tagDocArgs
+		if err := json.Unmarshal(cmd.Args, &args); err != nil {
+			return err
+		}
+		return f.withTx(func(tx execer) error {
+			return untagDoc(tx, args.Path, args.Tags)
+		})
+
+	case OpClearTags:
+		var args deleteDocArgs
+		if err := json.Unmarshal(cmd.Args, &args); err != nil {
+			return err
+		}
+		_, err := f.db.Exec(`delete from doc_tags where doc_id = (select id from docs where path = ?)`, args.Path)
+		return err
+
+	default:
+		return fmt.Errorf("unknown command op %q", cmd.Op)
+	}
+}
+
+func tagDoc(db execer, path string, tags []string) error {
+	id, err := docID(db, path)
+	if err != nil {
+		return err
+	}
+
+	for _, name := range tags {
+		if _, err := db.Exec(`insert into tags(name) values (?) on conflict (name) do nothing`, name); err != nil {
+			return err
+		}
+
+		var tagID int64
+		if err := db.QueryRow(`select id from tags where name = ?`, name).Scan(&tagID); err != nil {
+			return err
+		}
+
+		if _, err := db.Exec(`insert into doc_tags(doc_id, tag_id) values (?, ?) on conflict (doc_id, tag_id) do nothing`, id, tagID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func untagDoc(db execer, path string, tags []string) error {
+	id, err := docID(db, path)
+	if err != nil {
+		return err
+	}
+
+	for _, name := range tags {
+		_, err := db.Exec(`delete from doc_tags where doc_id = ? and tag_id = (select id from tags where name = ?)`, id, name)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// reconcileTags makes doc_tags for path match wanted exactly: tags no
+// longer present are removed, new ones are added. Ported from the
+// CLI's per-file frontmatter reconciliation so it now runs identically
+// on every node in the cluster.
+func reconcileTags(db execer, path string, wanted []string) error {
+	id, err := docID(db, path)
+	if err != nil {
+		return err
+	}
+
+	rows, err := db.Query(`select tg.name from tags tg join doc_tags dt on dt.tag_id = tg.id where dt.doc_id = ?`, id)
+	if err != nil {
+		return err
+	}
+
+	existing := map[string]bool{}
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			rows.Close()
+			return err
+		}
+		existing[name] = true
+	}
+	if err := rows.Err(); err != nil {
+		rows.Close()
+		return err
+	}
+	rows.Close()
+
+	want := map[string]bool{}
+	for _, name := range wanted {
+		want[name] = true
+	}
+
+	var toRemove []string
+	for name := range existing {
+		if !want[name] {
+			toRemove = append(toRemove, name)
+		}
+	}
+
+	var toAdd []string
+	for name := range want {
+		if !existing[name] {
+			toAdd = append(toAdd, name)
+		}
+	}
+
+	for _, name := range toRemove {
+		if _, err := db.Exec(`delete from doc_tags where doc_id = ? and tag_id = (select id from tags where name = ?)`, id, name); err != nil {
+			return err
+		}
+	}
+
+	return tagDoc(db, path, toAdd)
+}
+
+func docID(db execer, path string) (int64, error) {
+	var id int64
+	if err := db.QueryRow(`select id from docs where path = ?`, path).Scan(&id); err != nil {
+		return 0, fmt.Errorf("%w: doc not found: %s", err, path)
+	}
+	return id, nil
+}
+
+// Snapshot captures docs/tags/doc_tags so a follower can restore them
+// wholesale instead of replaying the whole log.
+func (f *fsm) Snapshot() (raft.FSMSnapshot, error) {
+	var buf bytes.Buffer
+	if err := encodeDump(f.db, &buf); err != nil {
+		return nil, err
+	}
+	return &fsmSnapshot{data: buf.Bytes()}, nil
+}
+
+// Restore replaces docs/tags/doc_tags with the contents of a snapshot
+// produced by Snapshot/Persist.
+func (f *fsm) Restore(rc io.ReadCloser) error {
+	defer rc.Close()
+
+	dump, err := decodeDump(rc)
+	if err != nil {
+		return fmt.Errorf("%w: failed to read snapshot", err)
+	}
+
+	return restoreDump(f.db, dump)
+}
+
+type fsmSnapshot struct {
+	data []byte
+}
+
+// Persist streams a full dump of docs/tags/doc_tags to sink, which is
+// what gets shipped to followers and replayed by Restore.
+func (s *fsmSnapshot) Persist(sink raft.SnapshotSink) error {
+	if _, err := sink.Write(s.data); err != nil {
+		sink.Cancel()
+		return err
+	}
+	return sink.Close()
+}
+
+func (s *fsmSnapshot) Release() {}
@@ -0,0 +1,54 @@
+package store
+
+import "database/sql"
+
+// ensureSchema creates docs/tags/doc_tags/docs_idx and their triggers
+// if they don't already exist. It's idempotent so it can run on every
+// Open — including an in-memory store standing up a brand new,
+// otherwise-empty database for a test cluster.
+func ensureSchema(db *sql.DB) error {
+	_, err := db.Exec(`create table if not exists docs (id integer primary key, path text not null unique, markdown text not null, html text, sha256 text)`)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec(`create table if not exists tags (id integer primary key, name text not null unique)`)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec(`create table if not exists doc_tags (
+		doc_id integer not null references docs(id) on delete cascade,
+		tag_id integer not null references tags(id) on delete cascade,
+		primary key (doc_id, tag_id)
+	)`)
+	if err != nil {
+		return err
+	}
+
+	// https://www.sqlite.org/fts5.html#external_content_and_contentless_tables
+	_, err = db.Exec(`create virtual table if not exists docs_idx using fts5(path, markdown, content='docs', content_rowid='id')`)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec(`CREATE TRIGGER IF NOT EXISTS docs_ai AFTER INSERT ON docs BEGIN
+  INSERT INTO docs_idx(rowid, path, markdown) VALUES (new.id, new.path, new.markdown);
+END;`)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec(`CREATE TRIGGER IF NOT EXISTS docs_ad AFTER DELETE ON docs BEGIN
+  INSERT INTO docs_idx(docs_idx, rowid, path, markdown) VALUES('delete', old.id, old.path, old.markdown);
+END;`)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec(`CREATE TRIGGER IF NOT EXISTS docs_au AFTER UPDATE ON docs BEGIN
+  INSERT INTO docs_idx(docs_idx, rowid, path, markdown) VALUES('delete', old.id, old.path, old.markdown);
+  INSERT INTO docs_idx(rowid, path, markdown) VALUES (new.id, new.path, new.markdown);
+END;`)
+	return err
+}
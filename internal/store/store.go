@@ -0,0 +1,223 @@
+// Package store wraps the search server's sqlite database in a Raft
+// group (à la rqlited/hraftd), so several nodes can serve reads against
+// a consistent view of docs while writes are replicated through a
+// single log. Reads hit the local database directly; every write
+// (indexing a doc, tagging, deleting) is submitted as a Command through
+// raft.Apply and executed by the FSM on every node.
+package store
+
+import (
+	"database/sql"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/raft"
+	raftboltdb "github.com/hashicorp/raft-boltdb"
+)
+
+// Config describes how to stand up a single node of the cluster.
+type Config struct {
+	NodeID   string
+	RaftDir  string
+	RaftBind string
+	DBPath   string
+	// InMemory runs the raft log/stable/snapshot stores and the sqlite
+	// database in memory, so tests can spin up ephemeral clusters
+	// without touching disk.
+	InMemory bool
+	// Bootstrap is true only for the node that forms a brand new
+	// single-node cluster; nodes that join an existing one via Join
+	// must leave this false.
+	Bootstrap bool
+}
+
+// Store owns the local sqlite connection and this node's participation
+// in the Raft group.
+type Store struct {
+	cfg  Config
+	db   *sql.DB
+	fsm  *fsm
+	raft *raft.Raft
+}
+
+// Open connects to cfg.DBPath (or an in-memory database when
+// cfg.InMemory is set), initializes the Raft subsystem, and, if
+// cfg.Bootstrap is set, forms a new single-node cluster around it.
+// Use Join on the leader to add further nodes afterwards.
+func Open(cfg Config) (*Store, error) {
+	dsn := cfg.DBPath
+	if cfg.InMemory {
+		dsn = "file:" + cfg.NodeID + "?mode=memory&cache=shared"
+	}
+	dsn = withForeignKeys(dsn)
+
+	db, err := sql.Open("sqlite3", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to open %s", err, dsn)
+	}
+
+	if err := ensureSchema(db); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("%w: failed to create schema", err)
+	}
+
+	s := &Store{cfg: cfg, db: db}
+	s.fsm = &fsm{db: db}
+
+	if err := s.openRaft(); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// withForeignKeys appends the go-sqlite3 DSN param that turns on
+// foreign-key enforcement — SQLite leaves it off by default per
+// connection, which would otherwise leave orphaned doc_tags rows
+// behind every time a referenced doc is deleted despite the `on delete
+// cascade` in its schema.
+func withForeignKeys(dsn string) string {
+	sep := "?"
+	if strings.Contains(dsn, "?") {
+		sep = "&"
+	}
+	return dsn + sep + "_foreign_keys=on"
+}
+
+func (s *Store) openRaft() error {
+	raftCfg := raft.DefaultConfig()
+	raftCfg.LocalID = raft.ServerID(s.cfg.NodeID)
+
+	addr, err := net.ResolveTCPAddr("tcp", s.cfg.RaftBind)
+	if err != nil {
+		return fmt.Errorf("%w: invalid raft bind address %s", err, s.cfg.RaftBind)
+	}
+
+	transport, err := raft.NewTCPTransport(s.cfg.RaftBind, addr, 3, 10*time.Second, os.Stderr)
+	if err != nil {
+		return fmt.Errorf("%w: failed to start raft transport", err)
+	}
+
+	logStore, stableStore, snapshotStore, err := s.openRaftStores()
+	if err != nil {
+		return err
+	}
+
+	r, err := raft.NewRaft(raftCfg, s.fsm, logStore, stableStore, snapshotStore, transport)
+	if err != nil {
+		return fmt.Errorf("%w: failed to start raft node", err)
+	}
+	s.raft = r
+
+	if s.cfg.Bootstrap {
+		cfg := raft.Configuration{
+			Servers: []raft.Server{{ID: raftCfg.LocalID, Address: transport.LocalAddr()}},
+		}
+		r.BootstrapCluster(cfg)
+	}
+
+	return nil
+}
+
+func (s *Store) openRaftStores() (raft.LogStore, raft.StableStore, raft.SnapshotStore, error) {
+	if s.cfg.InMemory {
+		return raft.NewInmemStore(), raft.NewInmemStore(), raft.NewInmemSnapshotStore(), nil
+	}
+
+	if err := os.MkdirAll(s.cfg.RaftDir, 0o755); err != nil {
+		return nil, nil, nil, fmt.Errorf("%w: failed to create raft dir %s", err, s.cfg.RaftDir)
+	}
+
+	boltDB, err := raftboltdb.NewBoltStore(filepath.Join(s.cfg.RaftDir, "raft.db"))
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("%w: failed to open raft log store", err)
+	}
+
+	snapshots, err := raft.NewFileSnapshotStore(s.cfg.RaftDir, 2, os.Stderr)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("%w: failed to open raft snapshot store", err)
+	}
+
+	return boltDB, boltDB, snapshots, nil
+}
+
+// DB returns the local database handle for read-only queries. Callers
+// must not write through it directly — use Apply so writes replicate.
+func (s *Store) DB() *sql.DB {
+	return s.db
+}
+
+// InMemory reports whether this node was configured with --memory.
+func (s *Store) InMemory() bool {
+	return s.cfg.InMemory
+}
+
+// IsLeader reports whether this node is currently the Raft leader and
+// may accept writes.
+func (s *Store) IsLeader() bool {
+	return s.raft.State() == raft.Leader
+}
+
+// WaitForLeader blocks until this node observes a Raft leader (itself
+// or another node) or timeout elapses. A freshly bootstrapped
+// single-node cluster needs a moment to elect itself before it can
+// accept writes; callers that are about to Apply should wait here
+// first rather than racing the election.
+func (s *Store) WaitForLeader(timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		if s.raft.Leader() != "" {
+			return nil
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	return fmt.Errorf("timed out after %s waiting for a raft leader", timeout)
+}
+
+// Apply submits cmd to the Raft log and blocks until it has been
+// committed by a majority and applied to this node's FSM; the other
+// nodes apply it to their own FSMs as they catch up. Must only be
+// called on the leader.
+func (s *Store) Apply(cmd Command, timeout time.Duration) error {
+	b, err := cmd.encode()
+	if err != nil {
+		return err
+	}
+
+	f := s.raft.Apply(b, timeout)
+	if err := f.Error(); err != nil {
+		return fmt.Errorf("%w: raft apply failed", err)
+	}
+	if err, ok := f.Response().(error); ok && err != nil {
+		return err
+	}
+	return nil
+}
+
+// Join adds nodeID, reachable at addr, as a voter in the cluster. Must
+// be called against the leader.
+func (s *Store) Join(nodeID, addr string) error {
+	f := s.raft.AddVoter(raft.ServerID(nodeID), raft.ServerAddress(addr), 0, 10*time.Second)
+	return f.Error()
+}
+
+// Leave removes nodeID from the cluster. Must be called against the
+// leader.
+func (s *Store) Leave(nodeID string) error {
+	f := s.raft.RemoveServer(raft.ServerID(nodeID), 0, 10*time.Second)
+	return f.Error()
+}
+
+// Close shuts down this node's Raft participation and closes the local
+// database.
+func (s *Store) Close() error {
+	if err := s.raft.Shutdown().Error(); err != nil {
+		return err
+	}
+	return s.db.Close()
+}